@@ -0,0 +1,54 @@
+package main
+
+import "fmt"
+
+// StreamEntry is a backend-agnostic view of a single stream entry, used so
+// that the producer/consumer/reclaimer goroutines never depend on a
+// particular Redis client library's types.
+type StreamEntry struct {
+	ID     string
+	Fields map[string]string
+}
+
+// streamClient abstracts the handful of stream commands the benchmark needs,
+// so that alternate connectivity backends (standalone, Cluster, TLS/ACL
+// protected) can be swapped in without touching the producer, consumer or
+// reclaimer goroutines.
+type streamClient interface {
+	XGroupDestroy(key, group string) error
+	XGroupCreate(key, group string) error
+	XLen(key string) (uint64, error)
+	XAdd(key string, maxlen int64, fields []string) (string, error)
+	XReadGroup(key, group, consumer string, count int, blockMs int) ([]StreamEntry, error)
+	XAck(key, group string, ids []string) error
+	XPending(key, group string, minIdleMs int64, count int) ([]string, error)
+	XClaim(key, group, consumer string, minIdleMs int64, ids []string) ([]StreamEntry, error)
+	Close() error
+}
+
+// clientConfig holds everything needed to connect to a Redis deployment,
+// whether that's a single node, a Cluster, or an Enterprise/ACL-protected
+// instance.
+type clientConfig struct {
+	Backend     string
+	Addrs       []string
+	ClusterMode bool
+	TLS         bool
+	TLSCAFile   string
+	Username    string
+	Password    string
+	DB          int
+}
+
+// newStreamClient builds the streamClient implementation selected by
+// cfg.Backend.
+func newStreamClient(cfg clientConfig) (streamClient, error) {
+	switch cfg.Backend {
+	case "radix", "":
+		return newRadixStreamClient(cfg)
+	case "go-redis":
+		return newRedisStreamClient(cfg)
+	default:
+		return nil, fmt.Errorf("unknown --client-backend %q: must be radix or go-redis", cfg.Backend)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// redisStreamClient is the go-redis/v8 backed streamClient. redis.UniversalClient
+// transparently becomes a Cluster client whenever cfg.ClusterMode requests it,
+// so this works against standalone, Cluster and Enterprise/ACL-protected
+// deployments alike.
+type redisStreamClient struct {
+	ctx    context.Context
+	client redis.UniversalClient
+}
+
+func newRedisStreamClient(cfg clientConfig) (streamClient, error) {
+	addrs := cfg.Addrs
+	if !cfg.ClusterMode && len(addrs) > 1 {
+		addrs = addrs[:1]
+	}
+
+	opts := &redis.UniversalOptions{
+		Addrs:    addrs,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	}
+	if cfg.TLS {
+		tlsConfig, err := loadTLSConfig(cfg.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+
+	return &redisStreamClient{ctx: context.Background(), client: redis.NewUniversalClient(opts)}, nil
+}
+
+func (r *redisStreamClient) XGroupDestroy(key, group string) error {
+	return r.client.XGroupDestroy(r.ctx, key, group).Err()
+}
+
+func (r *redisStreamClient) XGroupCreate(key, group string) error {
+	return r.client.XGroupCreate(r.ctx, key, group, "0").Err()
+}
+
+func (r *redisStreamClient) XLen(key string) (uint64, error) {
+	n, err := r.client.XLen(r.ctx, key).Result()
+	return uint64(n), err
+}
+
+func (r *redisStreamClient) XAdd(key string, maxlen int64, fields []string) (string, error) {
+	args := &redis.XAddArgs{Stream: key, Values: fields}
+	if maxlen > 0 {
+		args.MaxLen = maxlen
+		args.Approx = true
+	}
+	return r.client.XAdd(r.ctx, args).Result()
+}
+
+func (r *redisStreamClient) XReadGroup(key, group, consumer string, count int, blockMs int) ([]StreamEntry, error) {
+	args := &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{key, ">"},
+		Count:    int64(count),
+	}
+	if blockMs > 0 {
+		args.Block = time.Duration(blockMs) * time.Millisecond
+	}
+
+	streams, err := r.client.XReadGroup(r.ctx, args).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]StreamEntry, 0)
+	for _, s := range streams {
+		for _, m := range s.Messages {
+			entries = append(entries, StreamEntry{ID: m.ID, Fields: stringifyValues(m.Values)})
+		}
+	}
+	return entries, nil
+}
+
+func (r *redisStreamClient) XAck(key, group string, ids []string) error {
+	pipe := r.client.Pipeline()
+	for _, id := range ids {
+		pipe.XAck(r.ctx, key, group, id)
+	}
+	_, err := pipe.Exec(r.ctx)
+	return err
+}
+
+func (r *redisStreamClient) XPending(key, group string, minIdleMs int64, count int) ([]string, error) {
+	res, err := r.client.XPendingExt(r.ctx, &redis.XPendingExtArgs{
+		Stream: key,
+		Group:  group,
+		Idle:   time.Duration(minIdleMs) * time.Millisecond,
+		Start:  "-",
+		End:    "+",
+		Count:  int64(count),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(res))
+	for i, p := range res {
+		ids[i] = p.ID
+	}
+	return ids, nil
+}
+
+func (r *redisStreamClient) XClaim(key, group, consumer string, minIdleMs int64, ids []string) ([]StreamEntry, error) {
+	msgs, err := r.client.XClaim(r.ctx, &redis.XClaimArgs{
+		Stream:   key,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  time.Duration(minIdleMs) * time.Millisecond,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]StreamEntry, len(msgs))
+	for i, m := range msgs {
+		entries[i] = StreamEntry{ID: m.ID, Fields: stringifyValues(m.Values)}
+	}
+	return entries, nil
+}
+
+func (r *redisStreamClient) Close() error {
+	return r.client.Close()
+}
+
+func stringifyValues(values map[string]interface{}) map[string]string {
+	fields := make(map[string]string, len(values))
+	for k, v := range values {
+		fields[k] = fmt.Sprintf("%v", v)
+	}
+	return fields
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// radixStreamClient is the default streamClient backend, built on top of
+// radix/v3. It works against both a single instance (via a Pool) and a
+// Cluster deployment (via a Cluster), since both satisfy radix.Client.
+type radixStreamClient struct {
+	client radix.Client
+}
+
+func newRadixStreamClient(cfg clientConfig) (streamClient, error) {
+	var dialOpts []radix.DialOpt
+	if cfg.Username != "" {
+		dialOpts = append(dialOpts, radix.DialAuthUser(cfg.Username, cfg.Password))
+	} else if cfg.Password != "" {
+		dialOpts = append(dialOpts, radix.DialAuthPass(cfg.Password))
+	}
+	if cfg.DB > 0 {
+		dialOpts = append(dialOpts, radix.DialSelectDB(cfg.DB))
+	}
+	if cfg.TLS {
+		tlsConfig, err := loadTLSConfig(cfg.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		dialOpts = append(dialOpts, radix.DialUseTLS(tlsConfig))
+	}
+	connFunc := func(network, addr string) (radix.Conn, error) {
+		return radix.Dial(network, addr, dialOpts...)
+	}
+
+	if cfg.ClusterMode {
+		poolFunc := func(network, addr string) (radix.Client, error) {
+			return radix.NewPool(network, addr, 4, radix.PoolConnFunc(connFunc))
+		}
+		cluster, err := radix.NewCluster(cfg.Addrs, radix.ClusterPoolFunc(poolFunc))
+		if err != nil {
+			return nil, err
+		}
+		return &radixStreamClient{client: cluster}, nil
+	}
+
+	pool, err := radix.NewPool("tcp", cfg.Addrs[0], 4, radix.PoolConnFunc(connFunc))
+	if err != nil {
+		return nil, err
+	}
+	return &radixStreamClient{client: pool}, nil
+}
+
+func loadTLSConfig(caFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if caFile == "" {
+		return tlsConfig, nil
+	}
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("failed to parse CA certificate from %s", caFile)
+	}
+	tlsConfig.RootCAs = certPool
+	return tlsConfig, nil
+}
+
+func (r *radixStreamClient) XGroupDestroy(key, group string) error {
+	return r.client.Do(radix.Cmd(nil, "XGROUP", "DESTROY", key, group))
+}
+
+func (r *radixStreamClient) XGroupCreate(key, group string) error {
+	return r.client.Do(radix.Cmd(nil, "XGROUP", "CREATE", key, group, "0"))
+}
+
+func (r *radixStreamClient) XLen(key string) (uint64, error) {
+	var n uint64
+	err := r.client.Do(radix.Cmd(&n, "XLEN", key))
+	return n, err
+}
+
+func (r *radixStreamClient) XAdd(key string, maxlen int64, fields []string) (string, error) {
+	args := make([]string, 0, len(fields)+4)
+	if maxlen > 0 {
+		args = append(args, "MAXLEN", "~", strconv.FormatInt(maxlen, 10))
+	}
+	args = append(args, "*")
+	args = append(args, fields...)
+
+	var id string
+	err := r.client.Do(radix.Cmd(&id, "XADD", append([]string{key}, args...)...))
+	return id, err
+}
+
+func (r *radixStreamClient) XReadGroup(key, group, consumer string, count int, blockMs int) ([]StreamEntry, error) {
+	args := []string{"GROUP", group, consumer, "COUNT", strconv.Itoa(count)}
+	if blockMs > 0 {
+		args = append(args, "BLOCK", strconv.Itoa(blockMs))
+	}
+	args = append(args, "STREAMS", key, ">")
+
+	var streams []radix.StreamEntries
+	if err := r.client.Do(radix.Cmd(&streams, "XREADGROUP", args...)); err != nil {
+		return nil, err
+	}
+
+	entries := make([]StreamEntry, 0)
+	for _, s := range streams {
+		for _, e := range s.Entries {
+			entries = append(entries, StreamEntry{ID: e.ID.String(), Fields: e.Fields})
+		}
+	}
+	return entries, nil
+}
+
+func (r *radixStreamClient) XAck(key, group string, ids []string) error {
+	cmds := make([]radix.CmdAction, 0, len(ids))
+	for _, id := range ids {
+		cmds = append(cmds, radix.Cmd(nil, "XACK", key, group, id))
+	}
+	return r.client.Do(radix.Pipeline(cmds...))
+}
+
+func (r *radixStreamClient) XPending(key, group string, minIdleMs int64, count int) ([]string, error) {
+	args := []string{key, group, "IDLE", strconv.FormatInt(minIdleMs, 10), "-", "+", strconv.Itoa(count)}
+	var pending [][]string
+	if err := r.client.Do(radix.Cmd(&pending, "XPENDING", args...)); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(pending))
+	for i, p := range pending {
+		ids[i] = p[0]
+	}
+	return ids, nil
+}
+
+func (r *radixStreamClient) XClaim(key, group, consumer string, minIdleMs int64, ids []string) ([]StreamEntry, error) {
+	args := append([]string{key, group, consumer, strconv.FormatInt(minIdleMs, 10)}, ids...)
+	var claimed []radix.StreamEntry
+	if err := r.client.Do(radix.Cmd(&claimed, "XCLAIM", args...)); err != nil {
+		return nil, err
+	}
+
+	entries := make([]StreamEntry, len(claimed))
+	for i, e := range claimed {
+		entries[i] = StreamEntry{ID: e.ID.String(), Fields: e.Fields}
+	}
+	return entries, nil
+}
+
+func (r *radixStreamClient) Close() error {
+	return r.client.Close()
+}
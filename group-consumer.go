@@ -1,90 +1,137 @@
 package main
 
 import (
-	"log"
-	"sync"
+	"context"
+	"math/rand"
+	"strconv"
 	"sync/atomic"
 	"time"
-
-	"github.com/mediocregopher/radix/v3"
 )
 
-func groupConsumerRoutine(addr string, groupName string, consumerName string, keyName string, pcount int, stop chan struct{}, wg *sync.WaitGroup) {
-	// Tell the caller we've stopped
-	defer wg.Done()
+// groupConsumerRoutine reads and acks entries from keyName via groupName
+// until ctx is canceled. Once canceled, it stops issuing new XREADGROUP
+// calls but keeps acking whatever is already buffered in pending, giving it
+// up to shutdownTimeout to drain before returning, so a graceful shutdown
+// doesn't leave messages claimed-but-unacked. It returns immediately on the
+// first error from the client.
+//
+// An empty XREADGROUP result is only treated as "stream exhausted" when
+// doProduce is false: with a producer running concurrently, the stream is
+// never really finite, and a consumer that races ahead of production would
+// otherwise latch done and exit for good on the first empty poll.
+func groupConsumerRoutine(ctx context.Context, cfg clientConfig, groupName string, consumerName string, keyName string, pcount int, nackProbability float64, readCount int, readBlockMs int, ackBatchSize int, shutdownTimeout time.Duration, doProduce bool) error {
+	client, err := newStreamClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
 
-	conn, _ := bootstrapGroupConsumer(addr, consumerName)
-	defer conn.Close()
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
 
 	done := false
-	ids := make([]radix.StreamEntryID, 0)
-	readCmdArgs := []string{
-		"GROUP", groupName, consumerName,
-		"COUNT", "1",
-		"STREAMS", keyName, ">",
-	}
-	var entries []radix.StreamEntries
-	var startT, endT time.Time
-	var duration time.Duration
-	var id radix.StreamEntryID
-	var err error
+	pending := make([]StreamEntry, 0)
+	var drainDeadline time.Time
 
 	for {
-		select {
-		case <-stop:
-			return
-		default:
-			if len(ids) < pcount && !done {
-				startT = time.Now()
-				err = conn.Do(radix.Cmd(&entries, "XREADGROUP", readCmdArgs...))
-				if err != nil {
-					log.Fatal(err)
-				}
-				endT = time.Now()
-				duration = endT.Sub(startT)
-				err = readLatencies.RecordValue(duration.Microseconds())
-				if err != nil {
-					log.Fatalf("Received an error while recording latencies: %v", err)
-				}
-				if len(entries) == 0 {
-					done = true
-					continue
-				}
-				ids = append(ids, entries[0].Entries[0].ID)
-				atomic.AddUint64(&totalMessagesRead, uint64(1))
-			} else if len(ids) > 0 {
-				id = ids[0]
-				ids = ids[1:]
-				startT = time.Now()
-				err = conn.Do(radix.Cmd(nil, "XACK", keyName, groupName, id.String()))
-				if err != nil {
-					log.Fatal(err)
-				}
-				endT = time.Now()
-				duration = endT.Sub(startT)
-				err = ackLatencies.RecordValue(duration.Microseconds())
-				if err != nil {
-					log.Fatalf("Received an error while recording latencies: %v", err)
-				}
-				atomic.AddUint64(&totalMessagesAcked, uint64(1))
-			} else {
-				return
+		if ctx.Err() != nil {
+			if drainDeadline.IsZero() {
+				drainDeadline = time.Now().Add(shutdownTimeout)
 			}
+			if len(pending) == 0 || time.Now().After(drainDeadline) {
+				return nil
+			}
+			if err := ackBatch(client, keyName, groupName, &pending, ackBatchSize, rnd, nackProbability); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(pending) < pcount && !done {
+			startT := time.Now()
+			entries, err := client.XReadGroup(keyName, groupName, consumerName, readCount, readBlockMs)
+			if err != nil {
+				return err
+			}
+			endT := time.Now()
+			if err := readLatencies.RecordValue(endT.Sub(startT).Microseconds()); err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				done = !doProduce
+				continue
+			}
+			atomic.AddUint64(&totalReadBatches, uint64(1))
+			pending = append(pending, entries...)
+			atomic.AddUint64(&totalMessagesRead, uint64(len(entries)))
+		} else if len(pending) > 0 {
+			if err := ackBatch(client, keyName, groupName, &pending, ackBatchSize, rnd, nackProbability); err != nil {
+				return err
+			}
+		} else {
+			return nil
 		}
 	}
 }
 
-func bootstrapGroupConsumer(addr string, consumerName string) (radix.Conn, error) {
-	// Create a normal redis connection
-	conn, err := radix.Dial("tcp", addr)
-	if err != nil {
-		log.Fatal(err)
+// ackBatch pops up to ackBatchSize entries off the front of pending and
+// XACKs them, deliberately skipping a nackProbability fraction to simulate
+// a consumer crash and leave them pending for a reclaimer to pick up via
+// XPENDING/XCLAIM.
+func ackBatch(client streamClient, keyName string, groupName string, pending *[]StreamEntry, ackBatchSize int, rnd *rand.Rand, nackProbability float64) error {
+	p := *pending
+	batchSize := ackBatchSize
+	if batchSize <= 0 || batchSize > len(p) {
+		batchSize = len(p)
 	}
+	batch := p[:batchSize]
+	*pending = p[batchSize:]
 
-	err = conn.Do(radix.FlatCmd(nil, "CLIENT", "SETNAME", consumerName))
-	if err != nil {
-		log.Fatal(err)
+	ids := make([]string, 0, batchSize)
+	acked := make([]StreamEntry, 0, batchSize)
+	for _, entry := range batch {
+		if nackProbability > 0 && rnd.Float64() < nackProbability {
+			continue
+		}
+		ids = append(ids, entry.ID)
+		acked = append(acked, entry)
+	}
+	if len(ids) == 0 {
+		return nil
 	}
 
-	return conn, err
+	startT := time.Now()
+	if err := client.XAck(keyName, groupName, ids); err != nil {
+		return err
+	}
+	endT := time.Now()
+	if err := ackLatencies.RecordValue(endT.Sub(startT).Microseconds()); err != nil {
+		return err
+	}
+	for _, entry := range acked {
+		if err := recordEndToEndLatency(entry, endT); err != nil {
+			return err
+		}
+	}
+	atomic.AddUint64(&totalMessagesAcked, uint64(len(acked)))
+	return nil
+}
+
+// recordEndToEndLatency computes the time elapsed between the producer's
+// XADD (encoded in the entry's "ts" field, unix nanoseconds) and the
+// consumer's XACK. Entries produced without a "ts" field (e.g. pre-existing
+// streams) are skipped rather than treated as an error.
+func recordEndToEndLatency(entry StreamEntry, ackedAt time.Time) error {
+	raw, ok := entry.Fields["ts"]
+	if !ok {
+		return nil
+	}
+	producedAtNs, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return nil
+	}
+	latency := ackedAt.Sub(time.Unix(0, producedAtNs))
+	if latency < 0 {
+		return nil
+	}
+	return endToEndLatencies.RecordValue(latency.Microseconds())
 }
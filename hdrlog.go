@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// hdrLogWriter periodically flushes the rolling interval window of each
+// tracked histogram to a file in the standard HdrHistogram interval log
+// format (base64-encoded compressed histogram per interval, one line per
+// tick), so it can be post-processed with HistogramLogProcessor/hdr-plot.
+type hdrLogWriter struct {
+	file       *os.File
+	logWriter  *hdrhistogram.HistogramLogWriter
+	histograms []*intervalHistogram
+}
+
+func newHdrLogWriter(path string, histograms ...*intervalHistogram) (*hdrLogWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lw := hdrhistogram.NewHistogramLogWriter(file)
+	if err = lw.OutputLogFormatVersion(); err != nil {
+		return nil, err
+	}
+	if err = lw.OutputStartTime(time.Now().UnixMilli()); err != nil {
+		return nil, err
+	}
+	if err = lw.OutputLegend(); err != nil {
+		return nil, err
+	}
+
+	return &hdrLogWriter{file: file, logWriter: lw, histograms: histograms}, nil
+}
+
+// WriteIntervals rotates every tracked histogram's interval window out and
+// appends it as a line in the log.
+func (w *hdrLogWriter) WriteIntervals() error {
+	for _, h := range w.histograms {
+		if err := w.logWriter.OutputIntervalHistogram(h.Rotate()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *hdrLogWriter) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+const histogramLowestValue = 1
+const histogramHighestValue = 90000000
+const histogramSigFigs = 3
+
+// intervalHistogram records every value into both a cumulative histogram,
+// used for the final percentile summary, and a rolling interval window that
+// can be rotated out and flushed to an HdrHistogram interval log.
+type intervalHistogram struct {
+	tag string
+
+	mu           sync.Mutex
+	cumulative   *hdrhistogram.Histogram
+	interval     *hdrhistogram.Histogram
+	intervalFrom time.Time
+}
+
+func newIntervalHistogram(tag string) *intervalHistogram {
+	return &intervalHistogram{
+		tag:          tag,
+		cumulative:   hdrhistogram.New(histogramLowestValue, histogramHighestValue, histogramSigFigs),
+		interval:     hdrhistogram.New(histogramLowestValue, histogramHighestValue, histogramSigFigs),
+		intervalFrom: time.Now(),
+	}
+}
+
+func (h *intervalHistogram) RecordValue(v int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.cumulative.RecordValue(v); err != nil {
+		return err
+	}
+	return h.interval.RecordValue(v)
+}
+
+func (h *intervalHistogram) ValueAtQuantile(q float64) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cumulative.ValueAtQuantile(q)
+}
+
+// Rotate returns the values recorded since the last Rotate call, tagged and
+// timestamped for writing to an HdrHistogram interval log, and resets the
+// interval window.
+func (h *intervalHistogram) Rotate() *hdrhistogram.Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	snapshot := h.interval
+	snapshot.SetTag(h.tag)
+	snapshot.SetStartTimeMs(h.intervalFrom.UnixMilli())
+	snapshot.SetEndTimeMs(now.UnixMilli())
+
+	h.interval = hdrhistogram.New(histogramLowestValue, histogramHighestValue, histogramSigFigs)
+	h.intervalFrom = now
+	return snapshot
+}
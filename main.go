@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -9,31 +10,40 @@ import (
 	"os"
 	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
 	"text/tabwriter"
 	"time"
-
-	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
-	"github.com/mediocregopher/radix/v3"
 )
 
 var totalMessagesRead uint64
 var totalMessagesAcked uint64
-var readLatencies *hdrhistogram.Histogram
-var ackLatencies *hdrhistogram.Histogram
+var totalMessagesProduced uint64
+var totalMessagesReclaimed uint64
+var totalReadBatches uint64
+var readLatencies *intervalHistogram
+var ackLatencies *intervalHistogram
+var xaddLatencies *intervalHistogram
+var endToEndLatencies *intervalHistogram
+var xpendingLatencies *intervalHistogram
+var xclaimLatencies *intervalHistogram
 
 type testResult struct {
-	StartTime     int64     `json:"StartTime"`
-	Duration      float64   `json:"Duration"`
-	MessageRate   float64   `json:"MessageRate"`
-	TotalMessages uint64    `json:"TotalMessages"`
-	MessageRateTs []float64 `json:"MessageRateTs"`
+	StartTime              int64     `json:"StartTime"`
+	Duration               float64   `json:"Duration"`
+	MessageRate            float64   `json:"MessageRate"`
+	TotalMessages          uint64    `json:"TotalMessages"`
+	MessageRateTs          []float64 `json:"MessageRateTs"`
+	TotalMessagesProduced  uint64    `json:"TotalMessagesProduced"`
+	ProducerRate           float64   `json:"ProducerRate"`
+	ProducerRateTs         []float64 `json:"ProducerRateTs"`
+	TotalMessagesReclaimed uint64    `json:"TotalMessagesReclaimed"`
+	MeanEntriesPerRead     float64   `json:"MeanEntriesPerRead"`
 }
 
 func main() {
 	host := flag.String("host", "127.0.0.1", "Redis host.")
 	port := flag.Int("port", 6379, "Redis port.")
-	key_name := flag.String("key-name", "mystream", "Key name of the stream.")
+	key_name := flag.String("key-name", "mystream", "Key name(s) of the stream, comma-separated to spread consumers across several streams.")
 	message_count := flag.Uint64("message-count", 0, "Number of messages to process (0 means all).")
 	group_name := flag.String("group-name", "group", "Name of consumer group.")
 	group_consumer_prefix := flag.String("group-consumer-prefix", "consumer-", "Prefix for consumer name.")
@@ -41,76 +51,208 @@ func main() {
 	group_consumers_count := flag.Int("group-consumers-count", 1, "Number of consumers in group.")
 	json_out_file := flag.String("json-out-file", "", "Name of json output file, if not set, will not print to json.")
 	client_update_tick := flag.Int("client-update-tick", 1, "Client update tick.")
+	mode := flag.String("mode", "consume", "Benchmark mode: produce, consume or both.")
+	producers := flag.Int("producers", 0, "Number of publisher goroutines to run (mode produce/both).")
+	producer_rate := flag.Int("producer-rate", 0, "Target aggregate XADD rate in msgs/sec across all producers (0 means unthrottled).")
+	producer_payload_bytes := flag.Int("producer-payload-bytes", 100, "Size in bytes of the synthetic payload field.")
+	producer_field_count := flag.Int("producer-field-count", 0, "Number of extra fields to add to each produced entry.")
+	producer_maxlen := flag.Int64("producer-maxlen", 0, "MAXLEN ~ cap applied to XADD (0 means uncapped).")
+	nack_probability := flag.Float64("nack-probability", 0, "Fraction (0-1) of read messages that are deliberately left unacked, simulating a consumer crash.")
+	reclaim_consumers := flag.Int("reclaim-consumers", 0, "Number of reclaimer goroutines running XPENDING/XCLAIM to recover unacked messages.")
+	reclaim_min_idle_ms := flag.Int("reclaim-min-idle-ms", 5000, "Minimum idle time, in milliseconds, before a pending message is eligible for reclaim.")
+	reclaim_batch := flag.Int("reclaim-batch", 10, "Maximum number of pending messages fetched per XPENDING call.")
+	read_count := flag.Int("read-count", 1, "COUNT passed to XREADGROUP, i.e. how many entries to pull per read.")
+	read_block_ms := flag.Int("read-block-ms", 0, "BLOCK milliseconds passed to XREADGROUP (0 means don't block).")
+	ack_batch_size := flag.Int("ack-batch-size", 1, "Number of pending messages acked per pipelined XACK batch.")
+	client_backend := flag.String("client-backend", "radix", "Client backend to use: radix or go-redis.")
+	cluster_mode := flag.Bool("cluster-mode", false, "Connect to a Redis Cluster instead of a single instance.")
+	cluster_nodes := flag.String("cluster-nodes", "", "Comma-separated list of host:port cluster seed nodes (used when --cluster-mode is set, overrides --host/--port).")
+	tls_enabled := flag.Bool("tls", false, "Connect using TLS.")
+	tls_ca := flag.String("tls-ca", "", "Path to a PEM encoded CA certificate used to verify the server (used when --tls is set).")
+	username := flag.String("username", "", "ACL username to AUTH with.")
+	password := flag.String("password", "", "Password (or ACL password) to AUTH with.")
+	db := flag.Int("db", 0, "Database number to SELECT (ignored in cluster mode).")
+	hdr_latency_file := flag.String("hdr-latency-file", "", "Write XREADGROUP/XACK latency histograms in HdrHistogram interval log format to this file.")
+	metrics_listen := flag.String("metrics-listen", "", "Address to expose a Prometheus /metrics endpoint on (e.g. :9100). Empty disables it.")
+	shutdown_timeout := flag.Duration("shutdown-timeout", 10*time.Second, "Time a consumer is given to XACK its already-buffered messages after SIGINT/SIGTERM before giving up.")
 	flag.Parse()
 
-	totalMessagesRead = 0
-	totalMessagesAcked = 0
-	readLatencies = hdrhistogram.New(1, 90000000, 3)
-	ackLatencies = hdrhistogram.New(1, 90000000, 3)
+	if err := validateMode(*mode); err != nil {
+		log.Fatal(err)
+	}
+	doProduce := *mode == "produce" || *mode == "both"
+	doConsume := *mode == "consume" || *mode == "both"
 
-	stopChan := make(chan struct{})
-	connectionStr := fmt.Sprintf("%s:%d", *host, *port)
+	keyNames := strings.Split(*key_name, ",")
+	for i := range keyNames {
+		keyNames[i] = strings.TrimSpace(keyNames[i])
+	}
 
-	conn, err := radix.Dial("tcp", connectionStr)
-	if err != nil {
-		log.Fatal(err)
+	cfg := clientConfig{
+		Backend:     *client_backend,
+		ClusterMode: *cluster_mode,
+		TLS:         *tls_enabled,
+		TLSCAFile:   *tls_ca,
+		Username:    *username,
+		Password:    *password,
+		DB:          *db,
+	}
+	if *cluster_mode && *cluster_nodes != "" {
+		cfg.Addrs = strings.Split(*cluster_nodes, ",")
+	} else {
+		cfg.Addrs = []string{fmt.Sprintf("%s:%d", *host, *port)}
 	}
 
-	// Get length of stream in case message_count is 0.
-	if *message_count == 0 {
-		err = conn.Do(radix.Cmd(message_count, "XLEN", *key_name))
+	totalMessagesRead = 0
+	totalMessagesAcked = 0
+	totalMessagesProduced = 0
+	totalMessagesReclaimed = 0
+	totalReadBatches = 0
+	readLatencies = newIntervalHistogram("read")
+	ackLatencies = newIntervalHistogram("ack")
+	xaddLatencies = newIntervalHistogram("xadd")
+	endToEndLatencies = newIntervalHistogram("end-to-end")
+	xpendingLatencies = newIntervalHistogram("xpending")
+	xclaimLatencies = newIntervalHistogram("xclaim")
+
+	var hdrLog *hdrLogWriter
+	if *hdr_latency_file != "" {
+		var err error
+		hdrLog, err = newHdrLogWriter(*hdr_latency_file, readLatencies, ackLatencies)
 		if err != nil {
 			log.Fatal(err)
 		}
+		defer hdrLog.Close()
 	}
 
-	// Delete the group
-	destroyCmdArgs := []string{"DESTROY", *key_name, *group_name}
-	err = conn.Do(radix.Cmd(nil, "XGROUP", destroyCmdArgs...))
-	if err != nil {
-		log.Fatal(err)
+	svc := NewService()
+
+	if *metrics_listen != "" {
+		startMetricsServer(svc, *metrics_listen, time.Now())
 	}
 
-	// Create the group
-	createCmdArgs := []string{"CREATE", *key_name, *group_name, "0"}
-	err = conn.Do(radix.Cmd(nil, "XGROUP", createCmdArgs...))
+	client, err := newStreamClient(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// a WaitGroup for the goroutines to tell us they've stopped
-	wg := sync.WaitGroup{}
+	// Get length of stream(s) in case message_count is 0. Skip this when a
+	// producer is also running: the stream may still be empty at this point,
+	// and 0 would be mistaken for "target already reached" rather than
+	// "unbounded" below.
+	unboundedCount := doProduce && *message_count == 0
+	if doConsume && *message_count == 0 && !doProduce {
+		var total uint64
+		for _, key := range keyNames {
+			n, err := client.XLen(key)
+			if err != nil {
+				log.Fatal(err)
+			}
+			total += n
+		}
+		*message_count = total
+	}
 
-	for consumer_id := 1; consumer_id <= *group_consumers_count; consumer_id++ {
-		consumerName := fmt.Sprintf("%s%d", *group_consumer_prefix, consumer_id)
-		wg.Add(1)
-		go groupConsumerRoutine(connectionStr, *group_name, consumerName, *key_name, *group_consumer_max_pending, stopChan, &wg)
+	if doConsume {
+		for _, key := range keyNames {
+			// Delete the group
+			if err = client.XGroupDestroy(key, *group_name); err != nil {
+				log.Fatal(err)
+			}
+			// Create the group
+			if err = client.XGroupCreate(key, *group_name); err != nil {
+				log.Fatal(err)
+			}
+		}
 	}
+	client.Close()
+
+	if doConsume {
+		for consumer_id := 1; consumer_id <= *group_consumers_count; consumer_id++ {
+			consumerName := fmt.Sprintf("%s%d", *group_consumer_prefix, consumer_id)
+			key := keyNames[(consumer_id-1)%len(keyNames)]
+			svc.Go(func(ctx context.Context) error {
+				return groupConsumerRoutine(ctx, cfg, *group_name, consumerName, key, *group_consumer_max_pending, *nack_probability, *read_count, *read_block_ms, *ack_batch_size, *shutdown_timeout, doProduce)
+			})
+		}
 
-	// listen for C-c
+		for reclaimer_id := 1; reclaimer_id <= *reclaim_consumers; reclaimer_id++ {
+			consumerName := fmt.Sprintf("reclaimer-%d", reclaimer_id)
+			key := keyNames[(reclaimer_id-1)%len(keyNames)]
+			svc.Go(func(ctx context.Context) error {
+				return reclaimerRoutine(ctx, cfg, *group_name, consumerName, key, time.Duration(*reclaim_min_idle_ms)*time.Millisecond, *reclaim_batch)
+			})
+		}
+	}
+
+	if doProduce {
+		perProducerRate := 0
+		if *producer_rate > 0 && *producers > 0 {
+			perProducerRate = *producer_rate / *producers
+			if perProducerRate == 0 {
+				perProducerRate = 1
+			}
+		}
+		for producer_id := 1; producer_id <= *producers; producer_id++ {
+			key := keyNames[(producer_id-1)%len(keyNames)]
+			svc.Go(func(ctx context.Context) error {
+				return producerRoutine(ctx, cfg, key, perProducerRate, *producer_payload_bytes, *producer_field_count, *producer_maxlen)
+			})
+		}
+	}
+
+	// listen for Ctrl-c / a termination request, and stop the Service
+	// gracefully rather than crashing the process outright.
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	w := new(tabwriter.Writer)
 
+	workersDone := make(chan error, 1)
+	go func() { workersDone <- svc.Wait() }()
+
 	tick := time.NewTicker(time.Duration(*client_update_tick) * time.Second)
-	closed, start_time, duration, totalMessages, messageRateTs := updateCLI(tick, c, w, *message_count)
+	start_time, duration, totalMessages, messageRateTs, totalProduced, producerRateTs := updateCLI(tick, c, w, *message_count, doConsume && !unboundedCount, hdrLog, svc, workersDone)
 	messageRate := float64(totalMessages) / float64(duration.Seconds())
+	producerRate := float64(totalProduced) / float64(duration.Seconds())
+	meanEntriesPerRead := 0.0
+	if totalReadBatches > 0 {
+		meanEntriesPerRead = float64(totalMessages) / float64(totalReadBatches)
+	}
 
-	fmt.Fprint(w, fmt.Sprintf("#################################################\nTotal Duration %f Seconds\nMessage Rate %f\n#################################################\n", duration.Seconds(), messageRate))
+	fmt.Fprint(w, fmt.Sprintf("#################################################\nTotal Duration %f Seconds\nMessage Rate %f\nProducer Rate %f\n#################################################\n", duration.Seconds(), messageRate, producerRate))
 	fmt.Fprint(w, "\r\n")
 	w.Flush()
 
 	printLatencySummary(w, "XREADGROUP", readLatencies)
 	printLatencySummary(w, "XACK", ackLatencies)
+	if doProduce {
+		printLatencySummary(w, "XADD", xaddLatencies)
+	}
+	if doProduce && doConsume {
+		printLatencySummary(w, "End-to-end", endToEndLatencies)
+	}
+	if *reclaim_consumers > 0 {
+		printLatencySummary(w, "XPENDING", xpendingLatencies)
+		printLatencySummary(w, "XCLAIM", xclaimLatencies)
+		fmt.Fprintf(w, "Total Messages Reclaimed %d\n", totalMessagesReclaimed)
+	}
+	if doConsume {
+		fmt.Fprintf(w, "Mean Entries Per Read %.2f\n", meanEntriesPerRead)
+	}
 
 	if strings.Compare(*json_out_file, "") != 0 {
 
 		res := testResult{
-			StartTime:     start_time.Unix(),
-			Duration:      duration.Seconds(),
-			MessageRate:   messageRate,
-			TotalMessages: totalMessages,
-			MessageRateTs: messageRateTs,
+			StartTime:              start_time.Unix(),
+			Duration:               duration.Seconds(),
+			MessageRate:            messageRate,
+			TotalMessages:          totalMessages,
+			MessageRateTs:          messageRateTs,
+			TotalMessagesProduced:  totalProduced,
+			ProducerRate:           producerRate,
+			ProducerRateTs:         producerRateTs,
+			TotalMessagesReclaimed: totalMessagesReclaimed,
+			MeanEntriesPerRead:     meanEntriesPerRead,
 		}
 		file, err := json.MarshalIndent(res, "", " ")
 		if err != nil {
@@ -123,62 +265,87 @@ func main() {
 		}
 	}
 
-	if closed {
-		return
-	}
+}
 
-	// tell the goroutine to stop
-	close(stopChan)
-	// and wait for them both to reply back
-	wg.Wait()
+func validateMode(mode string) error {
+	switch mode {
+	case "produce", "consume", "both":
+		return nil
+	default:
+		return fmt.Errorf("invalid --mode %q: must be one of produce, consume, both", mode)
+	}
 }
 
-func updateCLI(tick *time.Ticker, c chan os.Signal, w *tabwriter.Writer, count uint64) (bool, time.Time, time.Duration, uint64, []float64) {
+// updateCLI renders the periodic tabwriter progress view and decides when a
+// run is over: either the target message count was acked (when
+// autoStopOnCount is set), in which case it stops the Service itself, or a
+// SIGINT/SIGTERM arrived, in which case it stops the Service and keeps
+// rendering progress while consumers drain their already-buffered messages.
+// Either way it only returns once every goroutine tracked by svc has
+// actually finished, so the report below reflects the final state rather
+// than a mid-drain snapshot.
+func updateCLI(tick *time.Ticker, c chan os.Signal, w *tabwriter.Writer, count uint64, autoStopOnCount bool, hdrLog *hdrLogWriter, svc *Service, workersDone <-chan error) (time.Time, time.Duration, uint64, []float64, uint64, []float64) {
 
 	start := time.Now()
 	prevTime := time.Now()
 	prevMessageCount := uint64(0)
+	prevProducedCount := uint64(0)
 	messageRateTs := []float64{}
+	producerRateTs := []float64{}
 
 	w.Init(os.Stdout, 25, 0, 1, ' ', tabwriter.AlignRight)
-	fmt.Fprint(w, fmt.Sprintf("Test Time\tTotal Messages\t Message Rate \t"))
+	fmt.Fprint(w, fmt.Sprintf("Test Time\tTotal Messages\t Message Rate \tTotal Produced\t Producer Rate \t"))
 	fmt.Fprint(w, "\n")
 	w.Flush()
 	for {
 		select {
 		case <-tick.C:
-			{
-				now := time.Now()
-				took := now.Sub(prevTime)
-				messageRate := float64(totalMessagesRead-prevMessageCount) / float64(took.Seconds())
-				if prevMessageCount == 0 && totalMessagesRead != 0 {
-					start = time.Now()
-				}
-				if totalMessagesRead != 0 {
-					messageRateTs = append(messageRateTs, messageRate)
+			now := time.Now()
+			took := now.Sub(prevTime)
+			messageRate := float64(totalMessagesRead-prevMessageCount) / float64(took.Seconds())
+			producedCount := totalMessagesProduced
+			producerRate := float64(producedCount-prevProducedCount) / float64(took.Seconds())
+			if prevMessageCount == 0 && prevProducedCount == 0 && (totalMessagesRead != 0 || producedCount != 0) {
+				start = time.Now()
+			}
+			if totalMessagesRead != 0 {
+				messageRateTs = append(messageRateTs, messageRate)
+			}
+			if producedCount != 0 {
+				producerRateTs = append(producerRateTs, producerRate)
+			}
+			prevMessageCount = totalMessagesRead
+			prevProducedCount = producedCount
+			prevTime = now
+
+			if hdrLog != nil {
+				if err := hdrLog.WriteIntervals(); err != nil {
+					log.Printf("Received an error while writing the HdrHistogram interval log: %v", err)
 				}
-				prevMessageCount = totalMessagesRead
-				prevTime = now
+			}
 
-				fmt.Fprint(w, fmt.Sprintf("%.0f\t%d\t%.2f\t", time.Since(start).Seconds(), totalMessagesRead, messageRate))
-				fmt.Fprint(w, "\r\n")
-				w.Flush()
+			fmt.Fprint(w, fmt.Sprintf("%.0f\t%d\t%.2f\t%d\t%.2f\t", time.Since(start).Seconds(), totalMessagesRead, messageRate, producedCount, producerRate))
+			fmt.Fprint(w, "\r\n")
+			w.Flush()
 
-				if totalMessagesAcked == count {
-					return false, start, time.Since(start), totalMessagesRead, messageRateTs
-				}
-				break
+			if autoStopOnCount && totalMessagesAcked == count {
+				svc.Stop()
 			}
 
 		case <-c:
-			fmt.Println("received Ctrl-c - shutting down")
-			return true, start, time.Since(start), totalMessagesRead, messageRateTs
+			fmt.Println("received shutdown signal - draining in-flight messages")
+			svc.Stop()
+
+		case err := <-workersDone:
+			if err != nil {
+				log.Printf("benchmark stopped with an error: %v", err)
+			}
+			return start, time.Since(start), totalMessagesRead, messageRateTs, totalMessagesProduced, producerRateTs
 		}
 	}
-	return false, start, time.Since(start), totalMessagesRead, messageRateTs
 }
 
-func printLatencySummary(w *tabwriter.Writer, n string, h *hdrhistogram.Histogram) {
+func printLatencySummary(w *tabwriter.Writer, n string, h *intervalHistogram) {
 	p50Ms := float64(h.ValueAtQuantile(50.0)) / 1000.0
 	p95Ms := float64(h.ValueAtQuantile(95.0)) / 1000.0
 	p99Ms := float64(h.ValueAtQuantile(99.0)) / 1000.0
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// startMetricsServer exposes the benchmark's running counters and latency
+// histograms as Prometheus metrics on listenAddr, so a long benchmark run
+// can be observed live instead of only yielding a report at the end. The
+// server is run as a goroutine tracked by svc, so a listen failure is
+// reported through the Service's error path rather than crashing the
+// process outright.
+func startMetricsServer(svc *Service, listenAddr string, start time.Time) {
+	registry := prometheus.NewRegistry()
+
+	registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "stream_messages_read_total",
+		Help: "Total number of stream entries read via XREADGROUP.",
+	}, func() float64 { return float64(atomic.LoadUint64(&totalMessagesRead)) }))
+
+	registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "stream_messages_acked_total",
+		Help: "Total number of stream entries acked via XACK.",
+	}, func() float64 { return float64(atomic.LoadUint64(&totalMessagesAcked)) }))
+
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "stream_rate_per_second",
+		Help: "Current XREADGROUP message rate, in messages per second.",
+	}, func() float64 {
+		return float64(atomic.LoadUint64(&totalMessagesRead)) / time.Since(start).Seconds()
+	}))
+
+	registry.MustRegister(newHistogramCollector("stream_read_latency_seconds", "XREADGROUP latency quantiles, in seconds.", readLatencies))
+	registry.MustRegister(newHistogramCollector("stream_ack_latency_seconds", "XACK latency quantiles, in seconds.", ackLatencies))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	srv := &http.Server{Addr: listenAddr, Handler: mux}
+	svc.Go(func(ctx context.Context) error {
+		errCh := make(chan error, 1)
+		go func() { errCh <- srv.ListenAndServe() }()
+		select {
+		case <-ctx.Done():
+			return srv.Close()
+		case err := <-errCh:
+			if err != nil && err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		}
+	})
+}
+
+// histogramCollector adapts an intervalHistogram's cumulative percentiles
+// into a Prometheus summary-style metric family with a "quantile" label.
+type histogramCollector struct {
+	hist *intervalHistogram
+	desc *prometheus.Desc
+}
+
+func newHistogramCollector(name, help string, hist *intervalHistogram) *histogramCollector {
+	return &histogramCollector{
+		hist: hist,
+		desc: prometheus.NewDesc(name, help, []string{"quantile"}, nil),
+	}
+}
+
+func (c *histogramCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *histogramCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		seconds := float64(c.hist.ValueAtQuantile(q*100)) / float64(time.Second/time.Microsecond)
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, seconds, strconv.FormatFloat(q, 'f', -1, 64))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// producerRoutine XADDs synthetic entries into keyName until ctx is
+// canceled. Each entry carries a "ts" field (unix nanoseconds at send time)
+// so that a consumer can derive end-to-end latency once it acks the
+// message, plus a "payload" field of payloadBytes bytes and fieldCount
+// additional filler fields. rate caps this goroutine's own send rate in
+// msgs/sec (0 means unthrottled), and maxlen, when > 0, is applied as a
+// MAXLEN ~ cap. It returns once ctx is canceled, or immediately on the
+// first error from the client.
+func producerRoutine(ctx context.Context, cfg clientConfig, keyName string, rate int, payloadBytes int, fieldCount int, maxlen int64) error {
+	client, err := newStreamClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	payload := strings.Repeat("A", payloadBytes)
+
+	var ticker *time.Ticker
+	if rate > 0 {
+		ticker = time.NewTicker(time.Second / time.Duration(rate))
+		defer ticker.Stop()
+	}
+
+	var startT time.Time
+
+	for {
+		if ticker != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+		}
+
+		fields := make([]string, 0, 4+fieldCount*2)
+		fields = append(fields, "ts", strconv.FormatInt(time.Now().UnixNano(), 10), "payload", payload)
+		for i := 0; i < fieldCount; i++ {
+			fields = append(fields, fmt.Sprintf("field%d", i), "v")
+		}
+
+		startT = time.Now()
+		_, err = client.XAdd(keyName, maxlen, fields)
+		if err != nil {
+			return err
+		}
+		if err = xaddLatencies.RecordValue(time.Since(startT).Microseconds()); err != nil {
+			return err
+		}
+		atomic.AddUint64(&totalMessagesProduced, uint64(1))
+	}
+}
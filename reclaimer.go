@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// reclaimerPollInterval is how often a reclaimer goroutine checks for
+// messages that have been pending for longer than minIdleTime.
+const reclaimerPollInterval = 100 * time.Millisecond
+
+// reclaimerRoutine periodically runs XPENDING to find messages that have
+// been idle for at least minIdleTime, then XCLAIMs and XACKs them, taking
+// over delivery from a consumer that is assumed to have crashed. This
+// exercises the same recovery path a production consumer group relies on.
+// It returns once ctx is canceled, or immediately on the first error from
+// the client.
+func reclaimerRoutine(ctx context.Context, cfg clientConfig, groupName string, consumerName string, keyName string, minIdleTime time.Duration, batchCount int) error {
+	client, err := newStreamClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	minIdleMs := minIdleTime.Milliseconds()
+
+	ticker := time.NewTicker(reclaimerPollInterval)
+	defer ticker.Stop()
+
+	var startT time.Time
+	var ids []string
+	var claimed []StreamEntry
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			startT = time.Now()
+			ids, err = client.XPending(keyName, groupName, minIdleMs, batchCount)
+			if err != nil {
+				return err
+			}
+			if err = xpendingLatencies.RecordValue(time.Since(startT).Microseconds()); err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				continue
+			}
+
+			startT = time.Now()
+			claimed, err = client.XClaim(keyName, groupName, consumerName, minIdleMs, ids)
+			if err != nil {
+				return err
+			}
+			if err = xclaimLatencies.RecordValue(time.Since(startT).Microseconds()); err != nil {
+				return err
+			}
+
+			if len(claimed) == 0 {
+				continue
+			}
+			claimedIDs := make([]string, len(claimed))
+			for i, entry := range claimed {
+				claimedIDs[i] = entry.ID
+			}
+			if err = client.XAck(keyName, groupName, claimedIDs); err != nil {
+				return err
+			}
+			atomic.AddUint64(&totalMessagesReclaimed, uint64(len(claimed)))
+			atomic.AddUint64(&totalMessagesAcked, uint64(len(claimed)))
+		}
+	}
+}
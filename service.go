@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Service tracks the lifecycle of the goroutines that make up a benchmark
+// run (producers, consumers, reclaimers), similar in spirit to Tendermint's
+// libs/service: Stop cancels a shared context so every goroutine can wind
+// down on its own terms, and Wait reports the first error any of them
+// returned rather than having each one crash the process with log.Fatal.
+type Service struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	group  *errgroup.Group
+}
+
+// NewService creates a Service ready to track goroutines started with Go.
+func NewService() *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	group, ctx := errgroup.WithContext(ctx)
+	return &Service{ctx: ctx, cancel: cancel, group: group}
+}
+
+// Context returns the Service's context, canceled once Stop is called.
+func (s *Service) Context() context.Context {
+	return s.ctx
+}
+
+// Go runs fn in a new goroutine tracked by the Service. The first non-nil
+// error returned by any fn started this way is what Wait ultimately
+// returns.
+func (s *Service) Go(fn func(ctx context.Context) error) {
+	s.group.Go(func() error { return fn(s.ctx) })
+}
+
+// Stop cancels the Service's context, signaling every tracked goroutine to
+// begin shutting down. It is safe to call more than once.
+func (s *Service) Stop() {
+	s.cancel()
+}
+
+// Wait blocks until every goroutine started with Go has returned, then
+// returns the first error any of them reported, or nil if none failed.
+func (s *Service) Wait() error {
+	return s.group.Wait()
+}